@@ -0,0 +1,32 @@
+// Package client is a thin wrapper around the official MongoDB Go driver.
+// It exists so pt-mongodb-summary's collectors can be written against a
+// single Connect call instead of repeating mongo.Connect/Ping boilerplate
+// at every dial site (the main connection, --serve mode's reconnect on
+// each interval, and the per-shard dials in GetReplicasetMembers and
+// oplog.GetOplogInfo).
+package client
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Connect dials MongoDB using opts and blocks until the connection is
+// confirmed with a ping, so callers get a usable *mongo.Client or a
+// descriptive error instead of discovering a bad URI on the first command.
+func Connect(ctx context.Context, opts *options.ClientOptions) (*mongo.Client, error) {
+	cl, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot connect to MongoDB")
+	}
+
+	if err := cl.Ping(ctx, nil); err != nil {
+		_ = cl.Disconnect(ctx)
+		return nil, errors.Wrap(err, "cannot ping MongoDB")
+	}
+
+	return cl, nil
+}
@@ -0,0 +1,140 @@
+// Package proto holds the BSON document shapes pt-mongodb-summary decodes
+// MongoDB command replies into. Keeping them here, rather than inline in
+// the tool, lets other mongolib consumers share the same wire structs.
+package proto
+
+import "time"
+
+// HostInfo is the reply to the hostInfo admin command.
+type HostInfo struct {
+	System struct {
+		Hostname string `bson:"hostname"`
+		CpuArch  string `bson:"cpuArch"`
+	} `bson:"system"`
+	Os struct {
+		Type string `bson:"type"`
+	} `bson:"os"`
+
+	DatabasesCount   int `bson:"databasesCount"`
+	CollectionsCount int `bson:"collectionsCount"`
+}
+
+// CommandLineOptions is the reply to the getCmdLineOpts admin command.
+type CommandLineOptions struct {
+	Parsed struct {
+		Storage struct {
+			DbPath string `bson:"dbPath"`
+		} `bson:"storage"`
+		Net struct {
+			SSL struct {
+				Mode string `bson:"mode"`
+			} `bson:"ssl"`
+		} `bson:"net"`
+	} `bson:"parsed"`
+
+	Security struct {
+		Authorization string `bson:"authorization"`
+		KeyFile       string `bson:"keyFile"`
+	} `bson:"security"`
+}
+
+// ReplStatus is the "repl" section of a serverStatus reply.
+type ReplStatus struct {
+	SetName string `bson:"setName"`
+}
+
+// OpcountStats is the "opcounters" section of a serverStatus reply.
+type OpcountStats struct {
+	Insert  int64 `bson:"insert"`
+	Query   int64 `bson:"query"`
+	Update  int64 `bson:"update"`
+	Delete  int64 `bson:"delete"`
+	GetMore int64 `bson:"getmore"`
+	Command int64 `bson:"command"`
+}
+
+// ServerStatus is the reply to the serverStatus admin command.
+type ServerStatus struct {
+	Pid           int64          `bson:"pid"`
+	Process       string         `bson:"process"`
+	Version       string         `bson:"version"`
+	Repl          *ReplStatus    `bson:"repl"`
+	Opcounters    *OpcountStats  `bson:"opcounters"`
+	StorageEngine *StorageEngine `bson:"storageEngine"`
+}
+
+// StorageEngine is the "storageEngine" section of a serverStatus reply. The
+// storage engine is a process-wide setting -- neither dbStats nor collStats
+// carry it per-database/per-collection -- so callers that want to label a
+// per-db or per-collection breakdown with it (CollStatsSummary/DBSummary)
+// have to read it once from here and reuse the same value everywhere.
+type StorageEngine struct {
+	Name string `bson:"name"`
+}
+
+// ShardsInfo is the reply to the listShards admin command.
+type ShardsInfo struct {
+	Shards []struct {
+		Host string `bson:"host"`
+	} `bson:"shards"`
+}
+
+// ReplicaSetStatus is the reply to the replSetGetStatus admin command.
+type ReplicaSetStatus struct {
+	Set     string    `bson:"set"`
+	Members []Members `bson:"members"`
+}
+
+// Members is one entry of ReplicaSetStatus.Members. Set, ReplLagSeconds
+// aren't part of replSetGetStatus's wire format: callers fill Set in after
+// decoding (it's only present once, on the parent document) and
+// pt-mongodb-summary's setReplLag computes ReplLagSeconds from OptimeDate
+// deltas against the primary.
+type Members struct {
+	Name       string    `bson:"name"`
+	StateStr   string    `bson:"stateStr"`
+	OptimeDate time.Time `bson:"optimeDate"`
+
+	Set            string  `bson:"-"`
+	ReplLagSeconds float64 `bson:"-"`
+}
+
+// MasterDoc is the reply to the isMaster admin command.
+type MasterDoc struct {
+	SetName *string  `bson:"setName"`
+	Hosts   []string `bson:"hosts"`
+	Msg     string   `bson:"msg"`
+}
+
+// BalancerStats summarizes config.changelog chunk-move/split/drop events
+// over the lookback window GetShardingChangelogStatus aggregates.
+type BalancerStats struct {
+	Success int64 `bson:"success"`
+	Failed  int64 `bson:"failed"`
+	Splits  int64 `bson:"splits"`
+	Drops   int64 `bson:"drops"`
+}
+
+// ShardingChangelogSummary is one bucket of the config.changelog
+// aggregation: a (event, note) pair and how many times it occurred.
+type ShardingChangelogSummary struct {
+	Id struct {
+		Event string `bson:"event"`
+		Note  string `bson:"note"`
+	} `bson:"_id"`
+	Count int `bson:"count"`
+}
+
+// ShardingChangelogStats wraps the aggregated ShardingChangelogSummary rows.
+type ShardingChangelogStats struct {
+	Items *[]ShardingChangelogSummary
+}
+
+// CollStats is the reply to the collStats command. There's no top-level
+// "storage engine" string here -- see ServerStatus.StorageEngine.
+type CollStats struct {
+	Size       int64 `bson:"size"`
+	IndexSize  int64 `bson:"totalIndexSize"`
+	AvgObjSize int64 `bson:"avgObjSize"`
+	Sharded    bool  `bson:"sharded"`
+}
@@ -1,26 +1,32 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"os"
+	"os/signal"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/howeyc/gopass"
 	"github.com/pborman/getopt"
 	"github.com/percona/percona-toolkit/src/go/lib/config"
-	"github.com/percona/percona-toolkit/src/go/lib/util"
 	"github.com/percona/percona-toolkit/src/go/lib/versioncheck"
+	"github.com/percona/percona-toolkit/src/go/mongolib/client"
 	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
 	"github.com/percona/percona-toolkit/src/go/pt-mongodb-summary/oplog"
 	"github.com/percona/percona-toolkit/src/go/pt-mongodb-summary/templates"
-	"github.com/percona/pmgo"
 	"github.com/pkg/errors"
 	"github.com/shirou/gopsutil/process"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -78,8 +84,8 @@ type procInfo struct {
 }
 
 type security struct {
-	Users int
-	Roles int
+	Users int64
+	Roles int64
 	Auth  string
 	SSL   string
 }
@@ -107,6 +113,38 @@ type clusterwideInfo struct {
 	UnshardedDataSize       int64 // bytes
 	UnshardedDataSizeScaled float64
 	UnshardedDataSizeScale  string
+
+	// Databases and Collections are only populated when --detail is set, so
+	// the default summary stays terse.
+	Databases   []DBSummary        `json:",omitempty"`
+	Collections []CollStatsSummary `json:",omitempty"`
+
+	TopCollectionsByDataSize  []CollStatsSummary `json:",omitempty"`
+	TopCollectionsByIndexSize []CollStatsSummary `json:",omitempty"`
+}
+
+// DBSummary is the per-database breakdown shown under --detail.
+type DBSummary struct {
+	Name          string
+	SizeOnDisk    int64
+	IndexSize     int64
+	AvgObjSize    int64
+	StorageEngine string
+	Collections   int
+}
+
+// CollStatsSummary is the per-collection breakdown shown under --detail, and
+// the unit used by the --top N lists.
+type CollStatsSummary struct {
+	DB            string
+	Name          string
+	SizeOnDisk    int64
+	IndexSize     int64
+	AvgObjSize    int64
+	StorageEngine string
+	Sharded       bool
+	ChunksCount   int
+	Shards        map[string]int64
 }
 
 type options struct {
@@ -117,6 +155,23 @@ type options struct {
 	LogLevel       string
 	Version        bool
 	NoVersionCheck bool
+
+	Serve         bool
+	ListenAddress string
+	Interval      time.Duration
+	Influx        bool
+
+	Detail bool
+	Top    int
+
+	TLS                     bool
+	TLSCAFile               string
+	TLSCertificateKeyFile   string
+	TLSAllowInvalidHostname bool
+	AuthMechanism           string
+
+	Concurrency int
+	RateLimit   float64
 }
 
 func main() {
@@ -126,11 +181,28 @@ func main() {
 	getopt.BoolVarLong(&opts.Version, "version", 'v', "", "Show version & exit")
 	getopt.BoolVarLong(&opts.NoVersionCheck, "no-version-check", 'c', "", "Don't check for updates")
 
-	getopt.StringVarLong(&opts.User, "user", 'u', "", "User name")
-	getopt.StringVarLong(&opts.Password, "password", 'p', "", "Password").SetOptional()
-	getopt.StringVarLong(&opts.AuthDB, "authenticationDatabase", 'a', "admin", "Database used to establish credentials and privileges with a MongoDB server")
+	getopt.StringVarLong(&opts.User, "user", 'u', "", "Deprecated: use a mongodb:// URI instead. User name")
+	getopt.StringVarLong(&opts.Password, "password", 'p', "", "Deprecated: use a mongodb:// URI instead. Password").SetOptional()
+	getopt.StringVarLong(&opts.AuthDB, "authenticationDatabase", 'a', "", "Deprecated: use a mongodb:// URI instead. Database used to establish credentials and privileges with a MongoDB server")
 	getopt.StringVarLong(&opts.LogLevel, "log-level", 'l', "error", "Log level:, panic, fatal, error, warn, info, debug")
-	getopt.SetParameters("host[:port]")
+
+	getopt.BoolVarLong(&opts.TLS, "tls", 0, "Connect using TLS")
+	getopt.StringVarLong(&opts.TLSCAFile, "tlsCAFile", 0, "", "Path to a PEM file with the CA used to validate the server certificate")
+	getopt.StringVarLong(&opts.TLSCertificateKeyFile, "tlsCertificateKeyFile", 0, "", "Path to a PEM file with the client certificate and private key")
+	getopt.BoolVarLong(&opts.TLSAllowInvalidHostname, "tlsAllowInvalidHostnames", 0, "Disable hostname verification of the server certificate")
+	getopt.StringVarLong(&opts.AuthMechanism, "authenticationMechanism", 0, "", "Authentication mechanism: SCRAM-SHA-1, SCRAM-SHA-256, MONGODB-X509, PLAIN, GSSAPI")
+
+	getopt.BoolVarLong(&opts.Serve, "serve", 0, "Run forever as a metrics exporter instead of printing the summary once")
+	getopt.StringVarLong(&opts.ListenAddress, "listen-address", 0, ":9216", "Address to expose Prometheus metrics on in --serve mode")
+	getopt.DurationVarLong(&opts.Interval, "interval", 0, 10*time.Second, "How often to collect stats in --serve mode")
+	getopt.BoolVarLong(&opts.Influx, "influx", 0, "In --serve mode, write InfluxDB line protocol to stdout instead of serving Prometheus metrics")
+
+	getopt.BoolVarLong(&opts.Detail, "detail", 0, "Show a per-database and per-collection breakdown")
+	getopt.IntVarLong(&opts.Top, "top", 0, 5, "With --detail, show the N largest collections by data size and by index size")
+
+	getopt.IntVarLong(&opts.Concurrency, "concurrency", 0, 4, "Number of collStats calls to run in parallel while scanning the cluster")
+	getopt.Float64VarLong(&opts.RateLimit, "rate-limit", 0, 0, "Max aggregate MB/s to read while scanning the cluster, 0 for unlimited")
+	getopt.SetParameters("host[:port] | mongodb://[user:pass@]host[:port][,...][/database][?options] | mongodb+srv://[user:pass@]host[/database][?options]")
 
 	getopt.Parse()
 	if *help {
@@ -145,7 +217,7 @@ func main() {
 
 	log.SetLevel(logLevel)
 
-	args := getopt.Args() // positional arg
+	args := getopt.Args() // positional arg: host[:port] or a mongodb(+srv):// URI
 	if len(args) > 0 {
 		opts.Host = args[0]
 	}
@@ -179,35 +251,76 @@ func main() {
 		opts.Password = string(pass)
 	}
 
-	di := &mgo.DialInfo{
-		Username: opts.User,
-		Password: opts.Password,
-		Addrs:    []string{opts.Host},
-		FailFast: true,
-		Source:   opts.AuthDB,
-	}
+	clientOpts, err := buildClientOptions(connOptions{
+		URI: opts.Host,
 
-	log.Debugf("Connecting to the db using:\n%+v", di)
-	dialer := pmgo.NewDialer()
+		TLS:                     opts.TLS,
+		TLSCAFile:               opts.TLSCAFile,
+		TLSCertificateKeyFile:   opts.TLSCertificateKeyFile,
+		TLSAllowInvalidHostname: opts.TLSAllowInvalidHostname,
+		AuthMechanism:           opts.AuthMechanism,
 
-	hostnames, err := getHostnames(dialer, di)
+		User:     opts.User,
+		Password: opts.Password,
+		AuthDB:   opts.AuthDB,
+	})
+	if err != nil {
+		log.Errorf("cannot parse connection parameters: %s", err)
+		os.Exit(1)
+	}
 
-	session, err := dialer.DialWithInfo(di)
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Info("received interrupt, cancelling in-flight scans")
+		cancel()
+	}()
+	defer cancel()
+
+	log.Debugf("Connecting to the db using:\n%+v", clientOpts)
+	mongoClient, err := client.Connect(ctx, clientOpts)
 	if err != nil {
 		log.Errorf("cannot connect to the db: %s", err)
 		os.Exit(1)
 	}
-	defer session.Close()
+	defer mongoClient.Disconnect(ctx)
+
+	hostnames, err := getHostnames(ctx, mongoClient, clientOpts)
+	if err != nil {
+		log.Errorf("cannot list shard hostnames: %s", err)
+	}
 
-	if replicaMembers, err := GetReplicasetMembers(dialer, hostnames, di); err != nil {
+	if opts.Serve {
+		serveOpts := serveOptions{
+			Enabled:       opts.Serve,
+			ListenAddress: opts.ListenAddress,
+			Interval:      opts.Interval,
+			Influx:        opts.Influx,
+			Concurrency:   opts.Concurrency,
+			RateLimit:     opts.RateLimit,
+		}
+		if err := runServe(ctx, clientOpts, hostnames, serveOpts); err != nil {
+			log.Errorf("cannot run in --serve mode: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if replicaMembers, err := GetReplicasetMembers(ctx, clientOpts, hostnames); err != nil {
 		log.Printf("[Error] cannot get replicaset members: %v\n", err)
 	} else {
+		elections, err := GetElectionHistory(ctx, mongoClient)
+		if err != nil {
+			log.Debugf("cannot get election history: %s", err)
+		}
 		t := template.Must(template.New("replicas").Parse(templates.Replicas))
-		t.Execute(os.Stdout, replicaMembers)
+		t.Execute(os.Stdout, replicasetSummary{Members: replicaMembers, Elections: elections})
 	}
 
 	//
-	if hostInfo, err := GetHostinfo(session); err != nil {
+	if hostInfo, err := GetHostinfo(ctx, mongoClient); err != nil {
 		log.Printf("[Error] cannot get host info: %v\n", err)
 	} else {
 		t := template.Must(template.New("hosttemplateData").Parse(templates.HostInfo))
@@ -216,21 +329,21 @@ func main() {
 
 	var sampleCount int64 = 5
 	var sampleRate time.Duration = 1 * time.Second // in seconds
-	if rops, err := GetOpCountersStats(session, sampleCount, sampleRate); err != nil {
+	if rops, err := GetOpCountersStats(ctx, mongoClient, sampleCount, sampleRate); err != nil {
 		log.Printf("[Error] cannot get Opcounters stats: %v\n", err)
 	} else {
 		t := template.Must(template.New("runningOps").Parse(templates.RunningOps))
 		t.Execute(os.Stdout, rops)
 	}
 
-	if security, err := GetSecuritySettings(session); err != nil {
+	if security, err := GetSecuritySettings(ctx, mongoClient); err != nil {
 		log.Printf("[Error] cannot get security settings: %v\n", err)
 	} else {
 		t := template.Must(template.New("ssl").Parse(templates.Security))
 		t.Execute(os.Stdout, security)
 	}
 
-	if oplogInfo, err := oplog.GetOplogInfo(hostnames, di); err != nil {
+	if oplogInfo, err := oplog.GetOplogInfo(ctx, hostnames, clientOpts); err != nil {
 		log.Printf("[Error] cannot get Oplog info: %v\n", err)
 	} else {
 		if len(oplogInfo) > 0 {
@@ -239,14 +352,14 @@ func main() {
 		}
 	}
 
-	if cwi, err := GetClusterwideInfo(session); err != nil {
+	if cwi, err := GetClusterwideInfo(ctx, mongoClient, opts.Detail, opts.Top, opts.Concurrency, opts.RateLimit); err != nil {
 		log.Printf("[Error] cannot get cluster wide info: %v\n", err)
 	} else {
 		t := template.Must(template.New("clusterwide").Parse(templates.Clusterwide))
 		t.Execute(os.Stdout, cwi)
 	}
 
-	if bs, err := GetBalancerStats(session); err != nil {
+	if bs, err := GetBalancerStats(ctx, mongoClient); err != nil {
 		log.Printf("[Error] cannot get balancer stats: %v\n", err)
 	} else {
 		t := template.Must(template.New("balancer").Parse(templates.BalancerStats))
@@ -255,21 +368,21 @@ func main() {
 
 }
 
-func GetHostinfo(session pmgo.SessionManager) (*hostInfo, error) {
+func GetHostinfo(ctx context.Context, cl *mongo.Client) (*hostInfo, error) {
 
 	hi := proto.HostInfo{}
-	if err := session.Run(bson.M{"hostInfo": 1}, &hi); err != nil {
+	if err := cl.Database("admin").RunCommand(ctx, bson.D{{"hostInfo", 1}}).Decode(&hi); err != nil {
 		return nil, errors.Wrap(err, "GetHostInfo.hostInfo")
 	}
 
 	cmdOpts := proto.CommandLineOptions{}
-	err := session.DB("admin").Run(bson.D{{"getCmdLineOpts", 1}, {"recordStats", 1}}, &cmdOpts)
+	err := cl.Database("admin").RunCommand(ctx, bson.D{{"getCmdLineOpts", 1}, {"recordStats", 1}}).Decode(&cmdOpts)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot get command line options")
 	}
 
 	ss := proto.ServerStatus{}
-	if err := session.DB("admin").Run(bson.D{{"serverStatus", 1}, {"recordStats", 1}}, &ss); err != nil {
+	if err := cl.Database("admin").RunCommand(ctx, bson.D{{"serverStatus", 1}, {"recordStats", 1}}).Decode(&ss); err != nil {
 		return nil, errors.Wrap(err, "GetHostInfo.serverStatus")
 	}
 
@@ -278,7 +391,7 @@ func GetHostinfo(session pmgo.SessionManager) (*hostInfo, error) {
 		pi.Error = err
 	}
 
-	nodeType, _ := getNodeType(session)
+	nodeType, _ := getNodeType(ctx, cl)
 
 	i := &hostInfo{
 		Hostname:          hi.System.Hostname,
@@ -307,24 +420,19 @@ func GetHostinfo(session pmgo.SessionManager) (*hostInfo, error) {
 	return i, nil
 }
 
-func getHostnames(dialer pmgo.Dialer, di *mgo.DialInfo) ([]string, error) {
-
-	session, err := dialer.DialWithInfo(di)
-	if err != nil {
-		return nil, err
-	}
-	defer session.Close()
-
+// getHostnames returns the seed host plus every shard's first host, read via
+// the listShards command. listShards only succeeds on a mongos; anywhere else
+// getHostnames returns an error and no hostnames.
+func getHostnames(ctx context.Context, cl *mongo.Client, clientOpts *options.ClientOptions) ([]string, error) {
 	shardsInfo := &proto.ShardsInfo{}
 	log.Debugf("Running 'listShards' command")
-	err = session.Run("listShards", shardsInfo)
+	err := cl.Database("admin").RunCommand(ctx, bson.D{{"listShards", 1}}).Decode(shardsInfo)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot list shards")
 	}
 
-	log.Debugf("listShards raw response: %+v", util.Pretty(shardsInfo))
-
-	hostnames := []string{di.Addrs[0]}
+	seedHost := clientOpts.Hosts[0]
+	hostnames := []string{seedHost}
 	if shardsInfo != nil {
 		for _, shardInfo := range shardsInfo.Shards {
 			m := strings.Split(shardInfo.Host, "/")
@@ -335,10 +443,30 @@ func getHostnames(dialer pmgo.Dialer, di *mgo.DialInfo) ([]string, error) {
 	return hostnames, nil
 }
 
-func GetClusterwideInfo(session pmgo.SessionManager) (*clusterwideInfo, error) {
+// dbStats is the reply to the dbStats command. There's no "storage engine"
+// field here either -- see the storageEngine lookup in GetClusterwideInfo.
+type dbStats struct {
+	DB         string `bson:"db"`
+	SizeOnDisk int64  `bson:"storageSize"`
+	IndexSize  int64  `bson:"indexSize"`
+	AvgObjSize int64  `bson:"avgObjSize"`
+}
+
+type collRef struct {
+	db   string
+	name string
+}
+
+// GetClusterwideInfo rolls up database and collection totals across the
+// cluster. collStats calls are fanned out across a pool of concurrency
+// workers, throttled to rateLimitMBps aggregate MB/s of reads, and cancelled
+// as soon as ctx is done. Results are aggregated under a mutex and the
+// returned Databases/Collections are sorted by name so output stays
+// deterministic regardless of how the workers interleave.
+func GetClusterwideInfo(ctx context.Context, cl *mongo.Client, detail bool, top int, concurrency int, rateLimitMBps float64) (*clusterwideInfo, error) {
 	var databases databases
 
-	err := session.Run(bson.M{"listDatabases": 1}, &databases)
+	err := cl.Database("admin").RunCommand(ctx, bson.D{{"listDatabases", 1}}).Decode(&databases)
 	if err != nil {
 		return nil, errors.Wrap(err, "GetClusterwideInfo.listDatabases ")
 	}
@@ -347,38 +475,214 @@ func GetClusterwideInfo(session pmgo.SessionManager) (*clusterwideInfo, error) {
 		TotalDBsCount: len(databases.Databases),
 	}
 
+	// The storage engine is a process-wide setting, not a per-database or
+	// per-collection one -- dbStats/collStats don't report it -- so it's
+	// read once from serverStatus and reused for every DBSummary/
+	// CollStatsSummary entry below.
+	var storageEngine string
+	if detail {
+		var ss proto.ServerStatus
+		if err := cl.Database("admin").RunCommand(ctx, bson.D{{"serverStatus", 1}}).Decode(&ss); err != nil {
+			log.Debugf("cannot get storage engine from serverStatus: %s", err)
+		} else if ss.StorageEngine != nil {
+			storageEngine = ss.StorageEngine.Name
+		}
+	}
+
+	var refs []collRef
+	dbStatsByName := map[string]*dbStats{}
 	for _, db := range databases.Databases {
-		collections, err := session.DB(db.Name).CollectionNames()
+		collections, err := cl.Database(db.Name).ListCollectionNames(ctx, bson.D{})
 		if err != nil {
 			continue
 		}
 		cwi.TotalCollectionsCount += len(collections)
-		for _, collName := range collections {
-			var collStats proto.CollStats
-			err := session.DB(db.Name).Run(bson.M{"collStats": collName}, &collStats)
-			if err != nil {
-				continue
+
+		if detail {
+			ds := &dbStats{DB: db.Name}
+			if err := cl.Database(db.Name).RunCommand(ctx, bson.D{{"dbStats", 1}}).Decode(ds); err != nil {
+				log.Debugf("cannot get dbStats for %s: %s", db.Name, err)
 			}
+			ds.DB = db.Name
+			dbStatsByName[db.Name] = ds
+		}
 
-			if collStats.Sharded {
-				cwi.ShardedDataSize += collStats.Size
-				cwi.ShardedColsCount++
-				continue
+		for _, collName := range collections {
+			refs = append(refs, collRef{db: db.Name, name: collName})
+		}
+	}
+
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	var limiter *rate.Limiter
+	if rateLimitMBps > 0 {
+		limiter = rate.NewLimiter(rate.Limit(rateLimitMBps*1024*1024), int(rateLimitMBps*1024*1024))
+	}
+
+	var mu sync.Mutex
+	jobs := make(chan collRef)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ref := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+
+				var collStats proto.CollStats
+				if err := cl.Database(ref.db).RunCommand(ctx, bson.D{{"collStats", ref.name}}).Decode(&collStats); err != nil {
+					continue
+				}
+
+				if limiter != nil && collStats.Size > 0 {
+					if err := waitRateLimit(ctx, limiter, collStats.Size); err != nil && ctx.Err() == nil {
+						log.Debugf("rate limiter wait failed for %s.%s: %s", ref.db, ref.name, err)
+					}
+				}
+
+				var chunksCount int
+				var shards map[string]int64
+				if detail && collStats.Sharded {
+					var err error
+					chunksCount, shards, err = getChunksDistribution(ctx, cl, ref.db+"."+ref.name)
+					if err != nil {
+						log.Debugf("cannot get chunk distribution for %s.%s: %s", ref.db, ref.name, err)
+					}
+				}
+
+				mu.Lock()
+				if collStats.Sharded {
+					cwi.ShardedDataSize += collStats.Size
+					cwi.ShardedColsCount++
+				} else {
+					cwi.UnshardedDataSize += collStats.Size
+					cwi.UnshardedColsCount++
+				}
+				if detail {
+					cwi.Collections = append(cwi.Collections, CollStatsSummary{
+						DB:            ref.db,
+						Name:          ref.name,
+						SizeOnDisk:    collStats.Size,
+						IndexSize:     collStats.IndexSize,
+						AvgObjSize:    collStats.AvgObjSize,
+						StorageEngine: storageEngine,
+						Sharded:       collStats.Sharded,
+						ChunksCount:   chunksCount,
+						Shards:        shards,
+					})
+					if ds, ok := dbStatsByName[ref.db]; ok {
+						ds.Collections++
+					}
+				}
+				mu.Unlock()
 			}
+		}()
+	}
 
-			cwi.UnshardedDataSize += collStats.Size
-			cwi.UnshardedColsCount++
+	for _, ref := range refs {
+		select {
+		case jobs <- ref:
+		case <-ctx.Done():
 		}
+	}
+	close(jobs)
+	wg.Wait()
 
+	for _, db := range databases.Databases {
+		ds, ok := dbStatsByName[db.Name]
+		if !ok {
+			continue
+		}
+		cwi.Databases = append(cwi.Databases, DBSummary{
+			Name:          ds.DB,
+			SizeOnDisk:    ds.SizeOnDisk,
+			IndexSize:     ds.IndexSize,
+			AvgObjSize:    ds.AvgObjSize,
+			StorageEngine: storageEngine,
+			Collections:   ds.Collections,
+		})
 	}
 
-	cwi.UnshardedColsCount = cwi.TotalCollectionsCount - cwi.ShardedColsCount
+	sort.Slice(cwi.Databases, func(i, j int) bool { return cwi.Databases[i].Name < cwi.Databases[j].Name })
+	sort.Slice(cwi.Collections, func(i, j int) bool {
+		if cwi.Collections[i].DB != cwi.Collections[j].DB {
+			return cwi.Collections[i].DB < cwi.Collections[j].DB
+		}
+		return cwi.Collections[i].Name < cwi.Collections[j].Name
+	})
+
 	cwi.ShardedDataSizeScaled, cwi.ShardedDataSizeScale = sizeAndUnit(cwi.ShardedDataSize)
 	cwi.UnshardedDataSizeScaled, cwi.UnshardedDataSizeScale = sizeAndUnit(cwi.UnshardedDataSize)
 
+	if detail && top > 0 {
+		cwi.TopCollectionsByDataSize = topCollections(cwi.Collections, top, func(c CollStatsSummary) int64 { return c.SizeOnDisk })
+		cwi.TopCollectionsByIndexSize = topCollections(cwi.Collections, top, func(c CollStatsSummary) int64 { return c.IndexSize })
+	}
+
 	return cwi, nil
 }
 
+// waitRateLimit throttles reading a size-byte collection to limiter's rate,
+// split into burst-sized waits -- WaitN returns immediately with an error
+// (no waiting at all) when asked to wait for more tokens than the limiter's
+// burst can ever hold, so a single WaitN(ctx, size) silently skips
+// throttling on any collection larger than the --rate-limit burst.
+func waitRateLimit(ctx context.Context, limiter *rate.Limiter, size int64) error {
+	burst := int64(limiter.Burst())
+	if burst <= 0 {
+		burst = 1
+	}
+	for size > 0 {
+		n := burst
+		if size < n {
+			n = size
+		}
+		if err := limiter.WaitN(ctx, int(n)); err != nil {
+			return err
+		}
+		size -= n
+	}
+	return nil
+}
+
+// getChunksDistribution returns the chunk count and per-shard chunk
+// distribution for a sharded namespace, read from config.chunks.
+func getChunksDistribution(ctx context.Context, cl *mongo.Client, ns string) (int, map[string]int64, error) {
+	cur, err := cl.Database("config").Collection("chunks").Find(ctx, bson.M{"ns": ns})
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "getChunksDistribution")
+	}
+
+	var chunks []struct {
+		Shard string `bson:"shard"`
+	}
+	if err := cur.All(ctx, &chunks); err != nil {
+		return 0, nil, errors.Wrap(err, "getChunksDistribution")
+	}
+
+	shards := make(map[string]int64)
+	for _, c := range chunks {
+		shards[c.Shard]++
+	}
+	return len(chunks), shards, nil
+}
+
+// topCollections returns, at most, the top n collections ranked by size in
+// descending order, without mutating the input slice.
+func topCollections(collections []CollStatsSummary, n int, size func(CollStatsSummary) int64) []CollStatsSummary {
+	sorted := make([]CollStatsSummary, len(collections))
+	copy(sorted, collections)
+	sort.Slice(sorted, func(i, j int) bool { return size(sorted[i]) > size(sorted[j]) })
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
 func sizeAndUnit(size int64) (float64, string) {
 	unit := []string{"bytes", "KB", "MB", "GB", "TB"}
 	idx := 0
@@ -391,39 +695,146 @@ func sizeAndUnit(size int64) (float64, string) {
 	return newSize, unit[idx]
 }
 
-func GetReplicasetMembers(dialer pmgo.Dialer, hostnames []string, di *mgo.DialInfo) ([]proto.Members, error) {
+// replicasetSummary is what gets rendered by templates.Replicas: the member
+// list (each carrying its own repl lag) plus recent election history.
+type replicasetSummary struct {
+	Members   []proto.Members
+	Elections []electionEvent
+}
+
+// electionEvent is a single "new primary" election or step-down, as recorded
+// in local.oplog.rs or config.changelog.
+type electionEvent struct {
+	Date    time.Time
+	Member  string
+	Message string
+}
+
+// GetReplicasetMembers dials every shard host in parallel, so scanning a
+// large cluster doesn't cost one dial round-trip per shard in sequence.
+// Each host gets its own *options.ClientOptions copy with Hosts overridden,
+// since the shared clientOpts must not be mutated concurrently.
+func GetReplicasetMembers(ctx context.Context, clientOpts *options.ClientOptions, hostnames []string) ([]proto.Members, error) {
+	var mu sync.Mutex
 	replicaMembers := []proto.Members{}
+	var wg sync.WaitGroup
 
 	for _, hostname := range hostnames {
-		di.Addrs = []string{hostname}
-		session, err := dialer.DialWithInfo(di)
-		if err != nil {
-			return nil, errors.Wrapf(err, "getReplicasetMembers. cannot connect to %s", hostname)
+		hostname := hostname
+		hostOpts := *clientOpts
+		hostOpts.SetHosts([]string{hostname})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ctx.Err() != nil {
+				return
+			}
+
+			cl, err := client.Connect(ctx, &hostOpts)
+			if err != nil {
+				log.Debugf("getReplicasetMembers: cannot connect to %s: %s", hostname, err)
+				return
+			}
+			defer cl.Disconnect(ctx)
+
+			rss := proto.ReplicaSetStatus{}
+			if err := cl.Database("admin").RunCommand(ctx, bson.D{{"replSetGetStatus", 1}}).Decode(&rss); err != nil {
+				return // If a host is a mongos we cannot get info but is not a real error
+			}
+
+			mu.Lock()
+			for _, m := range rss.Members {
+				m.Set = rss.Set
+				replicaMembers = append(replicaMembers, m)
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(replicaMembers, func(i, j int) bool { return replicaMembers[i].Name < replicaMembers[j].Name })
+	setReplLag(replicaMembers)
+
+	return replicaMembers, nil
+}
+
+// setReplLag computes each member's replication lag, in seconds, as the
+// delta between the primary's optimeDate and the member's own optimeDate.
+func setReplLag(members []proto.Members) {
+	var primaryOptime time.Time
+	for _, m := range members {
+		if m.StateStr == "PRIMARY" {
+			primaryOptime = m.OptimeDate
+		}
+	}
+	if primaryOptime.IsZero() {
+		return
+	}
+	for i, m := range members {
+		lag := primaryOptime.Sub(m.OptimeDate).Seconds()
+		if lag < 0 {
+			lag = 0
 		}
-		defer session.Close()
+		members[i].ReplLagSeconds = lag
+	}
+}
 
-		rss := proto.ReplicaSetStatus{}
-		err = session.Run(bson.M{"replSetGetStatus": 1}, &rss)
-		if err != nil {
-			continue // If a host is a mongos we cannot get info but is not a real error
+// GetElectionHistory returns recent primary elections and step-downs, newest
+// first, read from local.oplog.rs ("new primary" no-ops) and falling back to
+// config.changelog on mongos where the local oplog isn't queryable.
+func GetElectionHistory(ctx context.Context, cl *mongo.Client) ([]electionEvent, error) {
+	findOpts := options.Find().SetSort(bson.D{{"$natural", -1}}).SetLimit(20)
+	cur, err := cl.Database("local").Collection("oplog.rs").
+		Find(ctx, bson.M{"op": "n", "o.msg": "new primary"}, findOpts)
+	if err == nil {
+		var entries []struct {
+			Ts time.Time `bson:"ts"`
+			O  struct {
+				Msg string `bson:"msg"`
+			} `bson:"o"`
 		}
-		for _, m := range rss.Members {
-			m.Set = rss.Set
-			replicaMembers = append(replicaMembers, m)
+		if err := cur.All(ctx, &entries); err == nil && len(entries) > 0 {
+			events := make([]electionEvent, 0, len(entries))
+			for _, e := range entries {
+				events = append(events, electionEvent{Date: e.Ts, Message: e.O.Msg})
+			}
+			return events, nil
 		}
 	}
 
-	return replicaMembers, nil
+	changelogOpts := options.Find().SetSort(bson.D{{"time", -1}}).SetLimit(20)
+	cur, err = cl.Database("config").Collection("changelog").
+		Find(ctx, bson.M{"what": bson.M{"$in": []string{"new primary", "stepDown"}}}, changelogOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetElectionHistory")
+	}
+
+	var changelog []struct {
+		Time   time.Time `bson:"time"`
+		Server string    `bson:"server"`
+		What   string    `bson:"what"`
+		Note   string    `bson:"details.note"`
+	}
+	if err := cur.All(ctx, &changelog); err != nil {
+		return nil, errors.Wrap(err, "GetElectionHistory")
+	}
+
+	events := make([]electionEvent, 0, len(changelog))
+	for _, e := range changelog {
+		events = append(events, electionEvent{Date: e.Time, Member: e.Server, Message: e.What})
+	}
+	return events, nil
 }
 
-func GetSecuritySettings(session pmgo.SessionManager) (*security, error) {
+func GetSecuritySettings(ctx context.Context, cl *mongo.Client) (*security, error) {
 	s := security{
 		Auth: "disabled",
 		SSL:  "disabled",
 	}
 
 	cmdOpts := proto.CommandLineOptions{}
-	err := session.DB("admin").Run(bson.D{{"getCmdLineOpts", 1}, {"recordStats", 1}}, &cmdOpts)
+	err := cl.Database("admin").RunCommand(ctx, bson.D{{"getCmdLineOpts", 1}, {"recordStats", 1}}).Decode(&cmdOpts)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot get command line options")
 	}
@@ -435,12 +846,12 @@ func GetSecuritySettings(session pmgo.SessionManager) (*security, error) {
 		s.SSL = cmdOpts.Parsed.Net.SSL.Mode
 	}
 
-	s.Users, err = session.DB("admin").C("system.users").Count()
+	s.Users, err = cl.Database("admin").Collection("system.users").CountDocuments(ctx, bson.D{})
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot get users count")
 	}
 
-	s.Roles, err = session.DB("admin").C("system.roles").Count()
+	s.Roles, err = cl.Database("admin").Collection("system.roles").CountDocuments(ctx, bson.D{})
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot get roles count")
 	}
@@ -448,9 +859,9 @@ func GetSecuritySettings(session pmgo.SessionManager) (*security, error) {
 	return &s, nil
 }
 
-func getNodeType(session pmgo.SessionManager) (string, error) {
+func getNodeType(ctx context.Context, cl *mongo.Client) (string, error) {
 	md := proto.MasterDoc{}
-	err := session.Run("isMaster", &md)
+	err := cl.Database("admin").RunCommand(ctx, bson.D{{"isMaster", 1}}).Decode(&md)
 	if err != nil {
 		return "", err
 	}
@@ -465,7 +876,7 @@ func getNodeType(session pmgo.SessionManager) (string, error) {
 	return "mongod", nil
 }
 
-func GetOpCountersStats(session pmgo.SessionManager, count int64, sleep time.Duration) (*opCounters, error) {
+func GetOpCountersStats(ctx context.Context, cl *mongo.Client, count int64, sleep time.Duration) (*opCounters, error) {
 	oc := &opCounters{}
 	prevOpCount := &opCounters{}
 	ss := proto.ServerStatus{}
@@ -474,11 +885,16 @@ func GetOpCountersStats(session pmgo.SessionManager, count int64, sleep time.Dur
 	}
 
 	ticker := time.NewTicker(sleep)
+	defer ticker.Stop()
 	for i := int64(0); i < count+1; i++ {
-		<-ticker.C
-		err := session.DB("admin").Run(bson.D{{"serverStatus", 1}, {"recordStats", 1}}, &ss)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return oc, ctx.Err()
+		}
+		err := cl.Database("admin").RunCommand(ctx, bson.D{{"serverStatus", 1}, {"recordStats", 1}}).Decode(&ss)
 		if err != nil {
-			panic(err)
+			return nil, errors.Wrap(err, "GetOpCountersStats.serverStatus")
 		}
 
 		if i == 0 {
@@ -582,7 +998,6 @@ func GetOpCountersStats(session pmgo.SessionManager, count int64, sleep time.Dur
 		prevOpCount.GetMore.Total = ss.Opcounters.GetMore
 
 	}
-	ticker.Stop()
 
 	oc.Insert.Avg = oc.Insert.Total
 	oc.Query.Avg = oc.Query.Total
@@ -620,38 +1035,9 @@ func getProcInfo(pid int32, templateData *procInfo) error {
 	return nil
 }
 
-func getDbsAndCollectionsCount(hostnames []string) (int, int, error) {
-	dbnames := make(map[string]bool)
-	colnames := make(map[string]bool)
+func GetBalancerStats(ctx context.Context, cl *mongo.Client) (*proto.BalancerStats, error) {
 
-	for _, hostname := range hostnames {
-		session, err := mgo.Dial(hostname)
-		if err != nil {
-			continue
-		}
-		dbs, err := session.DatabaseNames()
-		if err != nil {
-			continue
-		}
-
-		for _, dbname := range dbs {
-			dbnames[dbname] = true
-			cols, err := session.DB(dbname).CollectionNames()
-			if err != nil {
-				continue
-			}
-			for _, colname := range cols {
-				colnames[dbname+"."+colname] = true
-			}
-		}
-	}
-
-	return len(dbnames), len(colnames), nil
-}
-
-func GetBalancerStats(session pmgo.SessionManager) (*proto.BalancerStats, error) {
-
-	scs, err := GetShardingChangelogStatus(session)
+	scs, err := GetShardingChangelogStatus(ctx, cl)
 	if err != nil {
 		return nil, err
 	}
@@ -679,17 +1065,21 @@ func GetBalancerStats(session pmgo.SessionManager) (*proto.BalancerStats, error)
 	return s, nil
 }
 
-func GetShardingChangelogStatus(session pmgo.SessionManager) (*proto.ShardingChangelogStats, error) {
-	var qresults []proto.ShardingChangelogSummary
-	coll := session.DB("config").C("changelog")
+func GetShardingChangelogStatus(ctx context.Context, cl *mongo.Client) (*proto.ShardingChangelogStats, error) {
+	coll := cl.Database("config").Collection("changelog")
 	match := bson.M{"time": bson.M{"$gt": time.Now().Add(-240 * time.Hour)}}
 	group := bson.M{"_id": bson.M{"event": "$what", "note": "$details.note"}, "count": bson.M{"$sum": 1}}
 
-	err := coll.Pipe([]bson.M{{"$match": match}, {"$group": group}}).All(&qresults)
+	cur, err := coll.Aggregate(ctx, []bson.M{{"$match": match}, {"$group": group}})
 	if err != nil {
 		return nil, errors.Wrap(err, "GetShardingChangelogStatus.changelog.find")
 	}
 
+	var qresults []proto.ShardingChangelogSummary
+	if err := cur.All(ctx, &qresults); err != nil {
+		return nil, errors.Wrap(err, "GetShardingChangelogStatus.changelog.find")
+	}
+
 	return &proto.ShardingChangelogStats{
 		Items: &qresults,
 	}, nil
@@ -0,0 +1,92 @@
+// Package oplog reads the size and time window of a replica set's oplog,
+// used by pt-mongodb-summary's Oplog report section and by its --serve
+// mode's repl_oplog_window_seconds metric.
+package oplog
+
+import (
+	"context"
+	"time"
+
+	"github.com/percona/percona-toolkit/src/go/mongolib/client"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Info is the oplog size and time window for a single host.
+type Info struct {
+	Host string
+	Size int64
+
+	FirstEvent time.Time
+	LastEvent  time.Time
+
+	// WindowSeconds is the time span covered by the oplog: the delta
+	// between the newest and the oldest entry in local.oplog.rs.
+	WindowSeconds float64
+}
+
+type oplogEntry struct {
+	Ts primitive.Timestamp `bson:"ts"`
+}
+
+// GetOplogInfo returns oplog Info for every host in hostnames that has a
+// queryable local.oplog.rs (mongos and arbiters don't, and are skipped).
+func GetOplogInfo(ctx context.Context, hostnames []string, clientOpts *options.ClientOptions) ([]Info, error) {
+	infos := make([]Info, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		hostOpts := *clientOpts
+		hostOpts.SetHosts([]string{hostname})
+
+		cl, err := client.Connect(ctx, &hostOpts)
+		if err != nil {
+			continue
+		}
+
+		info, err := oplogInfoForHost(ctx, cl, hostname)
+		cl.Disconnect(ctx)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	if len(infos) == 0 {
+		return nil, errors.New("no host in the list has a queryable oplog")
+	}
+	return infos, nil
+}
+
+func oplogInfoForHost(ctx context.Context, cl *mongo.Client, hostname string) (Info, error) {
+	coll := cl.Database("local").Collection("oplog.rs")
+
+	var first, last oplogEntry
+	firstOpts := options.FindOne().SetSort(bson.D{{"$natural", 1}})
+	if err := coll.FindOne(ctx, bson.D{}, firstOpts).Decode(&first); err != nil {
+		return Info{}, errors.Wrap(err, "cannot read first oplog entry")
+	}
+	lastOpts := options.FindOne().SetSort(bson.D{{"$natural", -1}})
+	if err := coll.FindOne(ctx, bson.D{}, lastOpts).Decode(&last); err != nil {
+		return Info{}, errors.Wrap(err, "cannot read last oplog entry")
+	}
+
+	var stats struct {
+		Size int64 `bson:"size"`
+	}
+	if err := cl.Database("local").RunCommand(ctx, bson.D{{"collStats", "oplog.rs"}}).Decode(&stats); err != nil {
+		return Info{}, errors.Wrap(err, "cannot get oplog.rs collStats")
+	}
+
+	firstTime := time.Unix(int64(first.Ts.T), 0)
+	lastTime := time.Unix(int64(last.Ts.T), 0)
+
+	return Info{
+		Host:          hostname,
+		Size:          stats.Size,
+		FirstEvent:    firstTime,
+		LastEvent:     lastTime,
+		WindowSeconds: lastTime.Sub(firstTime).Seconds(),
+	}, nil
+}
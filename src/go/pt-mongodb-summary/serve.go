@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/percona/percona-toolkit/src/go/mongolib/client"
+	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/percona/percona-toolkit/src/go/pt-mongodb-summary/oplog"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const metricsNamespace = "mongodb"
+
+// serveOptions holds the flags that control --serve mode.
+type serveOptions struct {
+	Enabled       bool
+	ListenAddress string
+	Interval      time.Duration
+	Influx        bool
+
+	Concurrency int
+	RateLimit   float64
+}
+
+// exporter periodically collects the same stats the one-shot summary prints
+// and exposes them either as Prometheus metrics or as InfluxDB line protocol
+// on stdout, similar to Telegraf's mongodb input.
+type exporter struct {
+	clientOpts *options.ClientOptions
+	hostnames  []string
+	opts       serveOptions
+
+	replLag       *prometheus.GaugeVec
+	oplogWindow   *prometheus.GaugeVec
+	shardChunks   *prometheus.GaugeVec
+	opcounters    *prometheus.GaugeVec
+	shardedBytes  *prometheus.GaugeVec
+	unshardedByte *prometheus.GaugeVec
+	balancerOK    *prometheus.GaugeVec
+	balancerFail  *prometheus.GaugeVec
+}
+
+func newExporter(clientOpts *options.ClientOptions, hostnames []string, opts serveOptions) *exporter {
+	labels := []string{"hostname", "replset", "shard"}
+	e := &exporter{
+		clientOpts: clientOpts,
+		hostnames:  hostnames,
+		opts:       opts,
+
+		replLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "repl_lag_seconds",
+			Help:      "Replication lag of this member behind the primary, in seconds",
+		}, labels),
+		oplogWindow: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "repl_oplog_window_seconds",
+			Help:      "Time span covered by the oplog, in seconds",
+		}, labels),
+		shardChunks: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "shard_chunks_total",
+			Help:      "Number of chunks owned by a shard",
+		}, labels),
+		opcounters: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "opcounters_total",
+			Help:      "Delta of opcounters over a one-second sample taken at each collection, by operation",
+		}, append(labels, "type")),
+		shardedBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "sharded_data_size_bytes",
+			Help:      "Total data size of sharded collections",
+		}, labels),
+		unshardedByte: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "unsharded_data_size_bytes",
+			Help:      "Total data size of unsharded collections",
+		}, labels),
+		balancerOK: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "balancer_success_total",
+			Help:      "Successful balancer chunk moves",
+		}, labels),
+		balancerFail: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "balancer_failed_total",
+			Help:      "Failed balancer chunk moves",
+		}, labels),
+	}
+
+	prometheus.MustRegister(
+		e.replLag, e.oplogWindow, e.shardChunks, e.opcounters,
+		e.shardedBytes, e.unshardedByte, e.balancerOK, e.balancerFail,
+	)
+	return e
+}
+
+// runServe turns the one-shot summary into a long-running metrics exporter.
+// It re-runs the default mode's collectors, except the security settings
+// and the election history, on opts.Interval and
+// exposes the results as Prometheus gauges on /metrics, or, when
+// opts.Influx is set, writes InfluxDB line protocol to stdout instead. ctx
+// is the cancellable context built in main() from SIGINT/SIGTERM, so Ctrl-C
+// stops the collection loop instead of waiting for the current interval.
+func runServe(ctx context.Context, clientOpts *options.ClientOptions, hostnames []string, opts serveOptions) error {
+	e := newExporter(clientOpts, hostnames, opts)
+
+	if !opts.Influx {
+		http.Handle("/metrics", promhttp.Handler())
+		go func() {
+			log.Infof("listening on %s", opts.ListenAddress)
+			if err := http.ListenAndServe(opts.ListenAddress, nil); err != nil {
+				log.Errorf("metrics server stopped: %s", err)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		if err := e.collectOnce(ctx); err != nil {
+			log.Errorf("cannot collect metrics: %s", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Info("stopping --serve mode")
+			return nil
+		}
+	}
+}
+
+func (e *exporter) collectOnce(ctx context.Context) error {
+	cl, err := client.Connect(ctx, e.clientOpts)
+	if err != nil {
+		return errors.Wrap(err, "collectOnce: cannot connect to the db")
+	}
+	defer cl.Disconnect(ctx)
+
+	addr := e.clientOpts.Hosts[0]
+
+	replset := ""
+	if hi, err := GetHostinfo(ctx, cl); err == nil {
+		replset = hi.ReplicasetName
+	}
+
+	var members []proto.Members
+	if ms, err := GetReplicasetMembers(ctx, e.clientOpts, e.hostnames); err != nil {
+		log.Errorf("cannot collect replicaset members: %s", err)
+	} else {
+		members = ms
+		e.collectReplLag(members, replset)
+	}
+
+	var oplogInfo []oplog.Info
+	if oi, err := oplog.GetOplogInfo(ctx, e.hostnames, e.clientOpts); err != nil {
+		log.Errorf("cannot collect oplog info: %s", err)
+	} else if len(oi) > 0 {
+		oplogInfo = oi
+		e.oplogWindow.WithLabelValues(addr, replset, "").Set(oi[0].WindowSeconds)
+	}
+
+	var oc *opCounters
+	if stats, err := GetOpCountersStats(ctx, cl, 1, time.Second); err != nil {
+		log.Errorf("cannot collect opcounters: %s", err)
+	} else {
+		oc = stats
+		e.opcounters.WithLabelValues(addr, replset, "", "insert").Set(float64(oc.Insert.Total))
+		e.opcounters.WithLabelValues(addr, replset, "", "query").Set(float64(oc.Query.Total))
+		e.opcounters.WithLabelValues(addr, replset, "", "update").Set(float64(oc.Update.Total))
+		e.opcounters.WithLabelValues(addr, replset, "", "delete").Set(float64(oc.Delete.Total))
+		e.opcounters.WithLabelValues(addr, replset, "", "getmore").Set(float64(oc.GetMore.Total))
+		e.opcounters.WithLabelValues(addr, replset, "", "command").Set(float64(oc.Command.Total))
+	}
+
+	// detail=true so Collections (and each sharded collection's per-shard
+	// chunk distribution) is populated for collectShardChunks below.
+	// Concurrency/RateLimit come from the same --concurrency/--rate-limit
+	// flags the one-shot summary uses, so --serve doesn't hammer the
+	// primary on every --interval tick just because those flags are
+	// supposed to be one-shot-only.
+	cwi, err := GetClusterwideInfo(ctx, cl, true, 0, e.opts.Concurrency, e.opts.RateLimit)
+	if err != nil {
+		log.Errorf("cannot collect clusterwide info: %s", err)
+	} else {
+		e.shardedBytes.WithLabelValues(addr, replset, "").Set(float64(cwi.ShardedDataSize))
+		e.unshardedByte.WithLabelValues(addr, replset, "").Set(float64(cwi.UnshardedDataSize))
+		e.collectShardChunks(cwi, addr, replset)
+	}
+
+	bs, err := GetBalancerStats(ctx, cl)
+	if err != nil {
+		log.Errorf("cannot collect balancer stats: %s", err)
+	} else {
+		e.balancerOK.WithLabelValues(addr, replset, "").Set(float64(bs.Success))
+		e.balancerFail.WithLabelValues(addr, replset, "").Set(float64(bs.Failed))
+	}
+
+	if e.opts.Influx {
+		e.writeInflux(addr, replset, members, oplogInfo, oc, cwi, bs)
+	}
+
+	return nil
+}
+
+func (e *exporter) collectReplLag(members []proto.Members, replset string) {
+	for _, m := range members {
+		e.replLag.WithLabelValues(m.Name, replset, "").Set(m.ReplLagSeconds)
+	}
+}
+
+// collectShardChunks sets shard_chunks_total for every shard that owns at
+// least one chunk of any sharded collection in the cluster.
+func (e *exporter) collectShardChunks(cwi *clusterwideInfo, addr, replset string) {
+	for shard, n := range shardChunkTotals(cwi) {
+		e.shardChunks.WithLabelValues(addr, replset, shard).Set(float64(n))
+	}
+}
+
+// shardChunkTotals sums, per shard, the chunk counts of every sharded
+// collection in cwi -- the same rollup the shard_chunks_total Prometheus
+// gauge and the InfluxDB mongodb_shard_chunks measurement both report.
+func shardChunkTotals(cwi *clusterwideInfo) map[string]int64 {
+	totals := map[string]int64{}
+	if cwi == nil {
+		return totals
+	}
+	for _, c := range cwi.Collections {
+		for shard, n := range c.Shards {
+			totals[shard] += n
+		}
+	}
+	return totals
+}
+
+// writeInflux renders the collected stats as InfluxDB line protocol, the way
+// Telegraf's mongodb input does, so the output can be piped straight into
+// `influx -import` or an InfluxDB line protocol listener. It mirrors the
+// same six metric families the Prometheus gauges expose: repl lag,
+// per-shard chunk counts, opcounters deltas, oplog window, and
+// sharded/unsharded byte totals plus balancer success/failed counts.
+func (e *exporter) writeInflux(addr, replset string, members []proto.Members, oplogInfo []oplog.Info, oc *opCounters, cwi *clusterwideInfo, bs *proto.BalancerStats) {
+	ts := time.Now().UnixNano()
+	tags := fmt.Sprintf("hostname=%s,replset=%s", addr, replset)
+
+	lines := []string{}
+	for _, m := range members {
+		lines = append(lines, fmt.Sprintf(
+			"mongodb_repl,%s,member=%s repl_lag_seconds=%.3f %d",
+			tags, m.Name, m.ReplLagSeconds, ts,
+		))
+	}
+	if len(oplogInfo) > 0 {
+		lines = append(lines, fmt.Sprintf(
+			"mongodb_oplog,%s repl_oplog_window_seconds=%.3f %d",
+			tags, oplogInfo[0].WindowSeconds, ts,
+		))
+	}
+	if oc != nil {
+		lines = append(lines, fmt.Sprintf(
+			"mongodb_opcounters,%s insert=%d,query=%d,update=%d,delete=%d,getmore=%d,command=%d %d",
+			tags, oc.Insert.Total, oc.Query.Total, oc.Update.Total, oc.Delete.Total, oc.GetMore.Total, oc.Command.Total, ts,
+		))
+	}
+	if cwi != nil {
+		lines = append(lines, fmt.Sprintf(
+			"mongodb_clusterwide,%s sharded_data_size_bytes=%d,unsharded_data_size_bytes=%d,sharded_collections=%d,unsharded_collections=%d %d",
+			tags, cwi.ShardedDataSize, cwi.UnshardedDataSize, cwi.ShardedColsCount, cwi.UnshardedColsCount, ts,
+		))
+		for shard, n := range shardChunkTotals(cwi) {
+			lines = append(lines, fmt.Sprintf("mongodb_shard_chunks,%s,shard=%s chunks_total=%d %d", tags, shard, n, ts))
+		}
+	}
+	if bs != nil {
+		lines = append(lines, fmt.Sprintf(
+			"mongodb_balancer,%s success=%d,failed=%d,splits=%d,drops=%d %d",
+			tags, bs.Success, bs.Failed, bs.Splits, bs.Drops, ts,
+		))
+	}
+
+	sort.Strings(lines)
+	for _, line := range lines {
+		fmt.Fprintln(os.Stdout, line)
+	}
+}
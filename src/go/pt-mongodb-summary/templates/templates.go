@@ -0,0 +1,105 @@
+// Package templates holds the html/template sources pt-mongodb-summary
+// renders the collected stats with. Keeping them as plain string constants,
+// one per report section, lets main.go parse and execute each section
+// independently against os.Stdout.
+package templates
+
+// HostInfo renders the host and process identification section.
+const HostInfo = `
+# This host
+      Hostname: {{.Hostname}}
+     OS Family: {{.HostOsType}}
+      CPU Arch: {{.HostSystemCPUArch}}
+     Databases: {{.HostDatabases}}
+   Collections: {{.HostCollections}}
+        DBPath: {{.DBPath}}
+
+# Process
+  Process Name: {{.ProcessName}}
+       Version: {{.Version}}
+     Node Type: {{.NodeType}}
+    Replicaset: {{.ReplicasetName}}
+          Path: {{.ProcPath}}
+          User: {{.ProcUserName}}
+    Start Time: {{.ProcCreateTime}}
+
+`
+
+// RunningOps renders the sampled opcounters section.
+const RunningOps = `
+# Running Ops
+          Insert    Query   Update   Delete  GetMore  Command
+  Min   {{.Insert.Min}}   {{.Query.Min}}   {{.Update.Min}}   {{.Delete.Min}}   {{.GetMore.Min}}   {{.Command.Min}}
+  Max   {{.Insert.Max}}   {{.Query.Max}}   {{.Update.Max}}   {{.Delete.Max}}   {{.GetMore.Max}}   {{.Command.Max}}
+  Total {{.Insert.Total}} {{.Query.Total}} {{.Update.Total}} {{.Delete.Total}} {{.GetMore.Total}} {{.Command.Total}}
+
+`
+
+// Security renders the auth/SSL and user/role counts section.
+const Security = `
+# Security Settings
+  Users: {{.Users}}
+  Roles: {{.Roles}}
+   Auth: {{.Auth}}
+    SSL: {{.SSL}}
+
+`
+
+// Oplog renders the oplog size and time window for the first host in the
+// host list that has a queryable oplog.
+const Oplog = `
+# Oplog
+          Host: {{.Host}}
+          Size: {{.Size}}
+   First Event: {{.FirstEvent}}
+    Last Event: {{.LastEvent}}
+  Window (sec): {{printf "%.0f" .WindowSeconds}}
+
+`
+
+// Replicas renders the replica set member list, each with its own repl
+// lag, plus recent election/step-down history when there is any.
+const Replicas = `
+# Replicaset Members
+{{range .Members}}  {{.Name}} ({{.StateStr}}) repl lag: {{printf "%.0f" .ReplLagSeconds}}s
+{{end}}
+{{if .Elections}}# Recent Elections
+{{range .Elections}}  {{.Date}} {{.Member}} {{.Message}}
+{{end}}
+{{end}}`
+
+// Clusterwide renders the cluster-wide database/collection rollup. The
+// Databases/Collections/Top sections only print when --detail populated
+// them; the default summary stays terse.
+const Clusterwide = `
+# Cluster wide summary
+              Databases: {{.TotalDBsCount}}
+            Collections: {{.TotalCollectionsCount}}
+    Sharded Collections: {{.ShardedColsCount}}
+  Unsharded Collections: {{.UnshardedColsCount}}
+       Sharded Data Size: {{printf "%.2f" .ShardedDataSizeScaled}} {{.ShardedDataSizeScale}}
+     Unsharded Data Size: {{printf "%.2f" .UnshardedDataSizeScaled}} {{.UnshardedDataSizeScale}}
+{{if .Databases}}
+# Databases
+{{range .Databases}}  {{.Name}} ({{.StorageEngine}}) size={{.SizeOnDisk}} indexSize={{.IndexSize}} collections={{.Collections}}
+{{end}}{{end}}{{if .Collections}}
+# Collections
+{{range .Collections}}  {{.DB}}.{{.Name}} size={{.SizeOnDisk}} indexSize={{.IndexSize}}{{if .Sharded}} sharded chunks={{.ChunksCount}}{{end}}
+{{end}}{{end}}{{if .TopCollectionsByDataSize}}
+# Top {{len .TopCollectionsByDataSize}} collections by data size
+{{range .TopCollectionsByDataSize}}  {{.DB}}.{{.Name}} {{.SizeOnDisk}}
+{{end}}{{end}}{{if .TopCollectionsByIndexSize}}
+# Top {{len .TopCollectionsByIndexSize}} collections by index size
+{{range .TopCollectionsByIndexSize}}  {{.DB}}.{{.Name}} {{.IndexSize}}
+{{end}}{{end}}
+`
+
+// BalancerStats renders the balancer chunk-move success/failure counts.
+const BalancerStats = `
+# Balancer Stats
+  Success: {{.Success}}
+   Failed: {{.Failed}}
+   Splits: {{.Splits}}
+    Drops: {{.Drops}}
+
+`
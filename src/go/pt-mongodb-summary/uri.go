@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// connOptions holds everything needed to build *options.ClientOptions from a
+// connection string (or the legacy --user/--password/--authenticationDatabase
+// flags): the
+// modern mongodb:// / mongodb+srv:// URI plus the mongo-shell-style TLS and
+// auth mechanism flags that don't have a flat query-string equivalent.
+type connOptions struct {
+	URI string
+
+	TLS                     bool
+	TLSCAFile               string
+	TLSCertificateKeyFile   string
+	TLSAllowInvalidHostname bool
+	AuthMechanism           string
+
+	// Legacy flags. Deprecated: use URI instead. Host is not read by
+	// buildClientOptions; a bare host[:port] goes in URI.
+	Host     string
+	User     string
+	Password string
+	AuthDB   string
+}
+
+var validAuthMechanisms = map[string]bool{
+	"SCRAM-SHA-1":   true,
+	"SCRAM-SHA-256": true,
+	"MONGODB-X509":  true,
+	"PLAIN":         true,
+	"GSSAPI":        true,
+}
+
+// buildClientOptions turns connOptions into *options.ClientOptions. The
+// driver's ApplyURI already understands mongodb:// and mongodb+srv://
+// natively -- SRV/TXT seedlist resolution, SCRAM/X.509/PLAIN/GSSAPI auth and
+// most TLS settings are all handled for us -- so this only needs to cover
+// the legacy flags and the mongo-shell-style --tls* flags that predate using
+// a URI at all.
+func buildClientOptions(opts connOptions) (*options.ClientOptions, error) {
+	if opts.AuthMechanism != "" && !validAuthMechanisms[opts.AuthMechanism] {
+		return nil, errors.Errorf("unsupported authentication mechanism %q", opts.AuthMechanism)
+	}
+
+	uri := opts.URI
+	if !strings.HasPrefix(uri, "mongodb://") && !strings.HasPrefix(uri, "mongodb+srv://") {
+		// Bare host[:port], same as the historical positional argument.
+		uri = "mongodb://" + uri
+	}
+
+	co := options.Client().ApplyURI(uri)
+	if err := co.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid connection string")
+	}
+
+	// Legacy flags fill in anything the URI/positional host didn't set, so
+	// old invocations keep working unchanged.
+	if opts.User != "" && co.Auth == nil {
+		co.SetAuth(options.Credential{
+			Username:   opts.User,
+			Password:   opts.Password,
+			AuthSource: opts.AuthDB,
+		})
+	}
+	if opts.AuthMechanism != "" {
+		cred := options.Credential{}
+		if co.Auth != nil {
+			cred = *co.Auth
+		}
+		cred.AuthMechanism = opts.AuthMechanism
+		co.SetAuth(cred)
+	}
+
+	if opts.TLS || opts.AuthMechanism == "MONGODB-X509" {
+		tlsConfig, err := buildTLSConfig(opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot build TLS config")
+		}
+		co.SetTLSConfig(tlsConfig)
+	}
+
+	return co, nil
+}
+
+// buildTLSConfig loads the CA and client certificate/key material named by
+// --tlsCAFile and --tlsCertificateKeyFile (a combined PEM, as produced by
+// the mongo shell's --tlsCertificateKeyFile), honoring
+// --tlsAllowInvalidHostnames for lab/self-signed setups.
+func buildTLSConfig(opts connOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if opts.TLSAllowInvalidHostname {
+		// InsecureSkipVerify disables Go's entire built-in verification --
+		// hostname match, chain of trust and expiry alike -- which is
+		// broader than the mongo shell's --tlsAllowInvalidHostnames, which
+		// only relaxes the hostname check. VerifyPeerCertificate puts the
+		// chain/expiry check back by hand, just without a DNSName, so only
+		// the hostname match ends up skipped.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = verifyChainIgnoringHostname(tlsConfig)
+	}
+
+	if opts.TLSCAFile != "" {
+		caCert, err := ioutil.ReadFile(opts.TLSCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read --tlsCAFile")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("--tlsCAFile does not contain a valid PEM certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.TLSCertificateKeyFile != "" {
+		pem, err := ioutil.ReadFile(opts.TLSCertificateKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read --tlsCertificateKeyFile")
+		}
+		cert, err := tls.X509KeyPair(pem, pem)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot parse --tlsCertificateKeyFile")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// verifyChainIgnoringHostname builds a tls.Config.VerifyPeerCertificate
+// callback that re-implements Go's default certificate verification against
+// cfg.RootCAs, minus the hostname check InsecureSkipVerify had to disable
+// along with everything else. cfg.RootCAs is read at call time (after
+// buildTLSConfig has finished populating it), not when this closure is
+// created.
+func verifyChainIgnoringHostname(cfg *tls.Config) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return errors.Wrap(err, "cannot parse peer certificate")
+			}
+			certs[i] = cert
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         cfg.RootCAs,
+			Intermediates: intermediates,
+		})
+		return err
+	}
+}